@@ -0,0 +1,36 @@
+package jssdk
+
+import "time"
+
+// Cache 是分布式票据缓存的抽象接口, 方便接入 Redis, Memcached, 数据库等共享存储.
+//
+//  NOTE: 实现者只需要保证 Get/Set/IsExist/Delete 本身是并发安全的即可, 同一时刻
+//  只会有一个进程持有 Locker 去调用 Set, 其余进程只会并发调用 Get.
+type Cache interface {
+	// Get 返回 key 对应的 value, exist == false 表示 key 不存在或者已经过期.
+	Get(key string) (value []byte, exist bool, err error)
+
+	// Set 设置 key 对应的 value, ttl <= 0 表示永不过期.
+	Set(key string, value []byte, ttl time.Duration) (err error)
+
+	// IsExist 判断 key 是否存在且未过期.
+	IsExist(key string) (exist bool, err error)
+
+	// Delete 删除 key.
+	Delete(key string) (err error)
+}
+
+// Locker 是分布式锁的抽象接口, 用于避免多个进程同时向微信服务器刷新同一个 ticket.
+//
+//  NOTE: 持有者可能因为刷新耗时超过 ttl 而被动丢失锁(锁自动过期, 被其他进程抢到),
+//  所以 TryLock 成功时会返回一个随机生成的 token, Unlock 必须带上这个 token,
+//  实现者需要保证只有 token 仍然匹配(即锁还是自己持有的那一把)时才真正释放, 否则会
+//  错误地释放掉其他进程刚刚抢到的锁.
+type Locker interface {
+	// TryLock 尝试加锁, ok == false 表示锁已经被其他进程持有, ttl 为锁的最大持有时间,
+	// 超过 ttl 之后锁会自动失效, 避免持有者异常退出导致锁永远得不到释放.
+	TryLock(key string, ttl time.Duration) (token string, ok bool, err error)
+
+	// Unlock 释放锁, 只有 token 和加锁时返回的一致才会真正释放.
+	Unlock(key, token string) (err error)
+}
@@ -0,0 +1,132 @@
+package jssdk
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// JsConfig 对应 JS-SDK config 接口(wx.config)所需要的签名参数.
+type JsConfig struct {
+	AppId     string `json:"appId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+// WxConfig 根据当前页面的 url 生成 wx.config 所需要的签名参数.
+//  appId 为公众号的 app_id, srv 用于获取当前有效的 jsapi_ticket,
+//  url 为当前网页的完整地址(不包含 # 及其后面的部分, 也不能做 url 编码).
+func WxConfig(srv TicketServer, appId, url string) (cfg JsConfig, err error) {
+	ticket, err := srv.JsapiTicket()
+	if err != nil {
+		return
+	}
+
+	nonceStr := randomNonceStr(16)
+	timestamp := time.Now().Unix()
+
+	signature := jsSHA1Sign(map[string]string{
+		"jsapi_ticket": ticket,
+		"noncestr":     nonceStr,
+		"timestamp":    strconv.FormatInt(timestamp, 10),
+		"url":          url,
+	})
+
+	cfg = JsConfig{
+		AppId:     appId,
+		Timestamp: timestamp,
+		NonceStr:  nonceStr,
+		Signature: signature,
+	}
+	return
+}
+
+// CardSignature 对应 Card JS-API(wx.chooseCard/wx.addCard)所需要的签名参数.
+type CardSignature struct {
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+// CardSign 计算 Card JS-API 所需要的签名.
+//  srv 用于获取当前有效的 card ticket, appId 为公众号的 app_id,
+//  cardId, code, openId 参见微信卡券相关文档, code, openId 可以为空.
+func CardSign(srv TicketServer, appId, cardId, code, openId string) (sign CardSignature, err error) {
+	ticket, err := srv.CardTicket()
+	if err != nil {
+		return
+	}
+
+	nonceStr := randomNonceStr(16)
+	timestamp := time.Now().Unix()
+	timestampStr := strconv.FormatInt(timestamp, 10)
+
+	signature := cardSHA1Sign(appId, cardId, ticket, code, openId, timestampStr, nonceStr)
+
+	sign = CardSignature{
+		Timestamp: timestamp,
+		NonceStr:  nonceStr,
+		Signature: signature,
+	}
+	return
+}
+
+// jsSHA1Sign 把 fields 按 key 的字典序排序后拼接成 key1=value1&key2=value2&... 的形式,
+// 计算 SHA1, 这是 JS-SDK config(wx.config) 的签名算法, 值为空的字段不参与签名.
+// Card JS-API 的签名用的是 cardSHA1Sign, 不是这个函数.
+func jsSHA1Sign(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha1.New()
+	for i, k := range keys {
+		if i > 0 {
+			h.Write([]byte("&"))
+		}
+		h.Write([]byte(k))
+		h.Write([]byte("="))
+		h.Write([]byte(fields[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cardSHA1Sign 是 Card JS-API(wx.chooseCard/wx.addCard)专用的签名算法: 把非空的
+// field 按字典序排序后直接拼接(不带字段名, 不带分隔符), 再计算 SHA1.
+//
+//  NOTE: 这和 jsSHA1Sign 用的 key=value&... 格式不一样, 是微信卡券 JS-API 文档里
+//  单独规定的签名算法, 不要弄混.
+func cardSHA1Sign(fields ...string) string {
+	values := make([]string, 0, len(fields))
+	for _, v := range fields {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+
+	h := sha1.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const nonceStrLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// randomNonceStr 生成一个随机字符串, 用作签名算法里的 noncestr.
+func randomNonceStr(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = nonceStrLetters[rand.Intn(len(nonceStrLetters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,43 @@
+package jssdk
+
+import "testing"
+
+func TestJsSHA1Sign(t *testing.T) {
+	// 官方 JS-SDK 文档给出的示例参数和签名结果.
+	got := jsSHA1Sign(map[string]string{
+		"jsapi_ticket": "sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg",
+		"noncestr":     "Wm3WZYTPz0wzccnW",
+		"timestamp":    "1414587457",
+		"url":          "http://mp.weixin.qq.com?params=value",
+	})
+	want := "0f9de62fce790f9a083d5c99e95740ceb90c27ed"
+	if got != want {
+		t.Fatalf("jsSHA1Sign mismatch: got %s, want %s", got, want)
+	}
+}
+
+func TestJsSHA1SignSkipsEmptyFields(t *testing.T) {
+	withEmpty := jsSHA1Sign(map[string]string{"a": "1", "b": "", "c": "3"})
+	withoutEmpty := jsSHA1Sign(map[string]string{"a": "1", "c": "3"})
+	if withEmpty != withoutEmpty {
+		t.Fatalf("empty field should not affect signature: %s != %s", withEmpty, withoutEmpty)
+	}
+}
+
+func TestCardSHA1SignSortsBareValuesWithoutKeys(t *testing.T) {
+	// 卡券 JS-API 签名算法: 对非空字段按值排序后直接拼接, 不带字段名和分隔符,
+	// 所以调用参数顺序不应该影响结果.
+	got := cardSHA1Sign("wxapp", "card123", "ticket-abc", "", "", "1414587457", "noncestr")
+	want := cardSHA1Sign("1414587457", "noncestr", "card123", "wxapp", "ticket-abc", "", "")
+	if got != want {
+		t.Fatalf("cardSHA1Sign should be order-independent: %s != %s", got, want)
+	}
+}
+
+func TestCardSHA1SignDiffersFromJsSHA1Sign(t *testing.T) {
+	cardSig := cardSHA1Sign("v1", "v2", "v3")
+	jsSig := jsSHA1Sign(map[string]string{"a": "v1", "b": "v2", "c": "v3"})
+	if cardSig == jsSig {
+		t.Fatalf("card signature algorithm must not match the key=value jsSHA1Sign format")
+	}
+}
@@ -0,0 +1,212 @@
+package jssdk
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/chanxuehong/wechat/mp/core"
+)
+
+// distributedTicketEntry 是存储在共享 Cache 中的 ticket 数据结构.
+type distributedTicketEntry struct {
+	AppId       string `json:"app_id"`
+	Ticket      string `json:"ticket"`
+	ExpiresIn   int64  `json:"expires_in"`
+	ExpiresTime int64  `json:"expires_time"` // unix 时间戳, 过期时间点
+}
+
+var _ TicketServer = (*DistributedTicketServer)(nil)
+
+// DistributedTicketServer 实现了 TicketServer 接口, 把 jsapi_ticket 存储在外部共享存储
+// (由 Cache 实现)里, 这样多个进程/多个实例可以共用同一个 jsapi_ticket, 只有抢到 Locker 的
+// 进程才会真正向微信服务器发起刷新请求, 其余进程直接读取共享缓存.
+//
+//  NOTE: 落地的共享存储由 Cache/Locker 实现, 内置了 Redis, Memcached, SQL 三种常见的实现
+//  (NewRedisTicketServer, NewMemcacheTicketServer, NewSQLTicketServer), 也可以自己实现
+//  Cache/Locker 接口接入其他存储.
+type DistributedTicketServer struct {
+	coreClient *core.Client
+	appId      string
+
+	cache  Cache
+	locker Locker
+
+	// localJsapiCache, localCardCache 是进程内的一级缓存, 用于减少对 Cache 的访问频率,
+	// 规则和 DefaultTicketServer 一致.
+	localJsapiCache jsapiTicketCache
+	localCardCache  jsapiTicketCache
+}
+
+// NewDistributedTicketServer 创建一个新的 DistributedTicketServer.
+//  appId 用于区分不同公众号在共享存储里的 key, cache 和 locker 通常来自同一个存储后端,
+//  比如 NewRedisTicketServer, NewMemcacheTicketServer, NewSQLTicketServer.
+func NewDistributedTicketServer(clt *core.Client, appId string, cache Cache, locker Locker) (srv *DistributedTicketServer) {
+	if clt == nil {
+		panic("nil core.Client")
+	}
+	if cache == nil {
+		panic("nil Cache")
+	}
+	if locker == nil {
+		panic("nil Locker")
+	}
+	return &DistributedTicketServer{
+		coreClient: clt,
+		appId:      appId,
+		cache:      cache,
+		locker:     locker,
+	}
+}
+
+func (srv *DistributedTicketServer) IIDB04E44A0E1DC11E5ADCEA4DB30FED8E1() {}
+
+func (srv *DistributedTicketServer) Ticket() (ticket string, err error) {
+	return srv.JsapiTicket()
+}
+
+func (srv *DistributedTicketServer) RefreshTicket(currentTicket string) (ticket string, err error) {
+	return srv.RefreshJsapiTicket(currentTicket)
+}
+
+func (srv *DistributedTicketServer) JsapiTicket() (ticket string, err error) {
+	return srv.ticket(ticketTypeJsapi, &srv.localJsapiCache)
+}
+
+func (srv *DistributedTicketServer) RefreshJsapiTicket(currentTicket string) (ticket string, err error) {
+	entry, err := srv.updateTicket(ticketTypeJsapi, currentTicket)
+	if err != nil {
+		return
+	}
+	return entry.Ticket, nil
+}
+
+func (srv *DistributedTicketServer) CardTicket() (ticket string, err error) {
+	return srv.ticket(ticketTypeCard, &srv.localCardCache)
+}
+
+func (srv *DistributedTicketServer) RefreshCardTicket(currentTicket string) (ticket string, err error) {
+	entry, err := srv.updateTicket(ticketTypeCard, currentTicket)
+	if err != nil {
+		return
+	}
+	return entry.Ticket, nil
+}
+
+func (srv *DistributedTicketServer) ticket(ticketType string, localCache *jsapiTicketCache) (ticket string, err error) {
+	if ticket = localCache.getTicket().Ticket; ticket != "" {
+		return
+	}
+	if entry, exist, err2 := srv.getCachedEntry(ticketType); err2 == nil && exist {
+		localCache.putTicketUntil(jsapiTicket{Ticket: entry.Ticket, ExpiresIn: entry.ExpiresIn}, entry.ExpiresTime)
+		return entry.Ticket, nil
+	}
+	entry, err := srv.updateTicket(ticketType, "")
+	if err != nil {
+		return
+	}
+	return entry.Ticket, nil
+}
+
+func (srv *DistributedTicketServer) cacheKey(ticketType string) string {
+	return "wechat_" + ticketType + "_ticket:" + srv.appId
+}
+
+func (srv *DistributedTicketServer) lockKey(ticketType string) string {
+	return "wechat_" + ticketType + "_ticket_lock:" + srv.appId
+}
+
+func (srv *DistributedTicketServer) localCacheFor(ticketType string) *jsapiTicketCache {
+	if ticketType == ticketTypeCard {
+		return &srv.localCardCache
+	}
+	return &srv.localJsapiCache
+}
+
+func (srv *DistributedTicketServer) getCachedEntry(ticketType string) (entry distributedTicketEntry, exist bool, err error) {
+	value, exist, err := srv.cache.Get(srv.cacheKey(ticketType))
+	if err != nil || !exist {
+		return
+	}
+	if err = json.Unmarshal(value, &entry); err != nil {
+		return
+	}
+	if entry.ExpiresTime <= time.Now().Unix() {
+		exist = false
+	}
+	return
+}
+
+// updateTicket 保证同一时刻只有一个进程会真正向微信服务器请求刷新指定类型的 ticket,
+// 其余进程要么直接读到共享缓存里尚未过期的 ticket, 要么在短暂等待之后读取抢锁者刷新的结果.
+func (srv *DistributedTicketServer) updateTicket(ticketType, currentTicket string) (entry distributedTicketEntry, err error) {
+	localCache := srv.localCacheFor(ticketType)
+
+	if cached, exist, err2 := srv.getCachedEntry(ticketType); err2 == nil && exist {
+		if currentTicket == "" || currentTicket != cached.Ticket {
+			localCache.putTicketUntil(jsapiTicket{Ticket: cached.Ticket, ExpiresIn: cached.ExpiresIn}, cached.ExpiresTime)
+			return cached, nil
+		}
+	}
+
+	const lockTTL = 10 * time.Second
+	token, ok, err := srv.locker.TryLock(srv.lockKey(ticketType), lockTTL)
+	if err != nil {
+		return
+	}
+	if !ok {
+		// 其他进程正在刷新, 等待一小会儿之后直接尝试读取它刷新出来的结果
+		time.Sleep(time.Duration(200+rand.Intn(300)) * time.Millisecond)
+		if cached, exist, err2 := srv.getCachedEntry(ticketType); err2 == nil && exist {
+			return cached, nil
+		}
+		err = errors.New("jssdk: " + ticketType + " ticket is being refreshed by another instance, please retry later")
+		return
+	}
+	// token 保证只有我们自己才能释放这把锁, 即使刷新耗时超过 lockTTL 导致锁提前过期、
+	// 被其他进程抢走, 这里也不会误删别人的锁.
+	defer srv.locker.Unlock(srv.lockKey(ticketType), token)
+
+	// double check, 避免在抢到锁之前其他进程已经刷新完成
+	if cached, exist, err2 := srv.getCachedEntry(ticketType); err2 == nil && exist {
+		if currentTicket == "" || currentTicket != cached.Ticket {
+			localCache.putTicketUntil(jsapiTicket{Ticket: cached.Ticket, ExpiresIn: cached.ExpiresIn}, cached.ExpiresTime)
+			return cached, nil
+		}
+	}
+
+	var incompleteURL = "https://api.weixin.qq.com/cgi-bin/ticket/getticket?type=" + ticketType + "&access_token="
+	var result struct {
+		core.Error
+		jsapiTicket
+	}
+	if err = srv.coreClient.GetJSON(incompleteURL, &result); err != nil {
+		return
+	}
+	if result.ErrCode != core.ErrCodeOK {
+		err = &result.Error
+		return
+	}
+
+	expiresIn, err := trimTicketExpiresIn(result.ExpiresIn)
+	if err != nil {
+		return
+	}
+
+	entry = distributedTicketEntry{
+		AppId:       srv.appId,
+		Ticket:      result.Ticket,
+		ExpiresIn:   expiresIn,
+		ExpiresTime: time.Now().Unix() + expiresIn,
+	}
+	value, err := json.Marshal(&entry)
+	if err != nil {
+		return
+	}
+	if err = srv.cache.Set(srv.cacheKey(ticketType), value, time.Duration(expiresIn)*time.Second); err != nil {
+		return
+	}
+	localCache.putTicketUntil(jsapiTicket{Ticket: entry.Ticket, ExpiresIn: entry.ExpiresIn}, entry.ExpiresTime)
+	return
+}
@@ -0,0 +1,91 @@
+package jssdk
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCache 是一个进程内的 Cache 实现, 只用于测试, 不依赖任何外部存储.
+type fakeCache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{items: make(map[string][]byte)}
+}
+
+func (c *fakeCache) Get(key string) (value []byte, exist bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, exist = c.items[key]
+	return
+}
+
+func (c *fakeCache) Set(key string, value []byte, ttl time.Duration) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = value
+	return nil
+}
+
+func (c *fakeCache) IsExist(key string) (exist bool, err error) {
+	_, exist, err = c.Get(key)
+	return
+}
+
+func (c *fakeCache) Delete(key string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+	return nil
+}
+
+// TestDistributedTicketServerLocalCacheExpires 复现了评审里指出的问题: 本地一级缓存只检查
+// ticket 是否为空, 从不检查是否过期, 导致 ticket 一旦写入本地缓存就永远不会再被刷新.
+func TestDistributedTicketServerLocalCacheExpires(t *testing.T) {
+	srv := &DistributedTicketServer{appId: "test", cache: newFakeCache()}
+
+	// 模拟本地缓存里有一个早就应该过期的 ticket.
+	srv.localJsapiCache.putTicketUntil(jsapiTicket{Ticket: "stale-ticket", ExpiresIn: 1}, time.Now().Unix()-1)
+
+	// 共享 Cache 里已经是另一个实例刷新出来的新 ticket.
+	fresh := distributedTicketEntry{
+		AppId:       "test",
+		Ticket:      "fresh-ticket",
+		ExpiresIn:   7200,
+		ExpiresTime: time.Now().Unix() + 7200,
+	}
+	value, err := json.Marshal(&fresh)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if err := srv.cache.Set(srv.cacheKey(ticketTypeJsapi), value, 0); err != nil {
+		t.Fatalf("cache.Set: %v", err)
+	}
+
+	ticket, err := srv.JsapiTicket()
+	if err != nil {
+		t.Fatalf("JsapiTicket: %v", err)
+	}
+	if ticket != "fresh-ticket" {
+		t.Fatalf("expired local cache entry should fall through to the shared cache, got %q", ticket)
+	}
+}
+
+// TestDistributedTicketServerLocalCacheStaysWarmWhileValid 确保没有过期之前仍然直接命中
+// 本地缓存, 不会每次都去读共享 Cache.
+func TestDistributedTicketServerLocalCacheStaysWarmWhileValid(t *testing.T) {
+	srv := &DistributedTicketServer{appId: "test", cache: newFakeCache()}
+	srv.localJsapiCache.putTicketUntil(jsapiTicket{Ticket: "warm-ticket", ExpiresIn: 7200}, time.Now().Unix()+7200)
+
+	ticket, err := srv.JsapiTicket()
+	if err != nil {
+		t.Fatalf("JsapiTicket: %v", err)
+	}
+	if ticket != "warm-ticket" {
+		t.Fatalf("expected to hit the still-valid local cache, got %q", ticket)
+	}
+}
@@ -0,0 +1,121 @@
+package jssdk
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"github.com/chanxuehong/wechat/mp/core"
+)
+
+var _ Cache = (*MemcacheCache)(nil)
+
+// MemcacheCache 基于 memcache.Client 实现 Cache 接口.
+type MemcacheCache struct {
+	client *memcache.Client
+}
+
+// NewMemcacheCache 创建一个新的 MemcacheCache.
+func NewMemcacheCache(client *memcache.Client) *MemcacheCache {
+	if client == nil {
+		panic("nil memcache.Client")
+	}
+	return &MemcacheCache{client: client}
+}
+
+func (c *MemcacheCache) Get(key string) (value []byte, exist bool, err error) {
+	item, err := c.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return item.Value, true, nil
+}
+
+func (c *MemcacheCache) Set(key string, value []byte, ttl time.Duration) (err error) {
+	var expiration int32 // 0 表示永不过期
+	if ttl > 0 {
+		expiration = int32((ttl + time.Second - 1) / time.Second)
+	}
+	return c.client.Set(&memcache.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: expiration,
+	})
+}
+
+func (c *MemcacheCache) IsExist(key string) (exist bool, err error) {
+	_, exist, err = c.Get(key)
+	return
+}
+
+func (c *MemcacheCache) Delete(key string) (err error) {
+	err = c.client.Delete(key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return
+}
+
+var _ Locker = (*MemcacheLocker)(nil)
+
+// MemcacheLocker 利用 memcache 的 Add 语义(key 已存在则失败, 等价于 redis 的 NX)实现分布式锁,
+// value 是加锁时生成的随机 token, 解锁时通过 Get + CompareAndSwap(CAS) 保证只删除自己的锁.
+type MemcacheLocker struct {
+	client *memcache.Client
+}
+
+// NewMemcacheLocker 创建一个新的 MemcacheLocker.
+func NewMemcacheLocker(client *memcache.Client) *MemcacheLocker {
+	if client == nil {
+		panic("nil memcache.Client")
+	}
+	return &MemcacheLocker{client: client}
+}
+
+func (l *MemcacheLocker) TryLock(key string, ttl time.Duration) (token string, ok bool, err error) {
+	token = randomNonceStr(32)
+	err = l.client.Add(&memcache.Item{
+		Key:        key,
+		Value:      []byte(token),
+		Expiration: int32(ttl / time.Second),
+	})
+	if err == memcache.ErrNotStored {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+func (l *MemcacheLocker) Unlock(key, token string) (err error) {
+	item, err := l.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if string(item.Value) != token {
+		// 锁已经不是我们持有的那一把(过期后被其他实例抢到了), 不能删除.
+		return nil
+	}
+
+	item.Value = nil
+	item.Expiration = 1 // 1 秒之后过期, 相当于删除
+	err = l.client.CompareAndSwap(item)
+	if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+		// 在我们读取之后、CAS 之前锁又发生了变化, 说明已经不是我们的锁了, 不再处理.
+		return nil
+	}
+	return err
+}
+
+// NewMemcacheTicketServer 创建一个新的基于 Memcached 的 DistributedTicketServer, 多个
+// 进程共用同一个 memcache 集群即可共享同一个 jsapi_ticket.
+func NewMemcacheTicketServer(clt *core.Client, appId string, client *memcache.Client) (srv *DistributedTicketServer) {
+	return NewDistributedTicketServer(clt, appId, NewMemcacheCache(client), NewMemcacheLocker(client))
+}
@@ -0,0 +1,42 @@
+package jssdk
+
+import "time"
+
+// Observer 定义了 ticket 刷新生命周期里的观测点, 用于接入监控指标/结构化日志/链路追踪.
+// 所有方法都会被高频调用, 实现时应当避免阻塞操作.
+type Observer interface {
+	// OnRefreshStart 在真正向微信服务器发起 getticket 请求之前调用, 返回的 token 会原样
+	// 传给这一次刷新对应的 OnRefreshSuccess/OnRefreshError 调用, 用来关联同一次刷新的
+	// 开始和结束(比如 OTelObserver 用它传递对应的 trace.Span), 不需要的实现可以忽略它.
+	OnRefreshStart(ticketType string) (token interface{})
+
+	// OnRefreshSuccess 在 getticket 请求成功并写入缓存之后调用, token 是对应的
+	// OnRefreshStart 的返回值.
+	OnRefreshSuccess(ticketType, ticket string, expiresIn int64, latency time.Duration, token interface{})
+
+	// OnRefreshError 在 getticket 请求失败之后调用, attempt 从 0 开始计数, token 是对应的
+	// OnRefreshStart 的返回值.
+	OnRefreshError(ticketType string, err error, attempt int, token interface{})
+
+	// OnCacheHit 在直接命中本地缓存, 不需要刷新的时候调用.
+	OnCacheHit(ticketType string)
+
+	// OnCacheMiss 在本地缓存没有命中, 需要触发一次刷新的时候调用.
+	OnCacheMiss(ticketType string)
+}
+
+// NopObserver 是 Observer 的空实现, 自定义 Observer 时可以匿名嵌入它, 只覆盖关心的方法.
+type NopObserver struct{}
+
+var _ Observer = NopObserver{}
+
+func (NopObserver) OnRefreshStart(ticketType string) (token interface{}) { return nil }
+
+func (NopObserver) OnRefreshSuccess(ticketType, ticket string, expiresIn int64, latency time.Duration, token interface{}) {
+}
+
+func (NopObserver) OnRefreshError(ticketType string, err error, attempt int, token interface{}) {}
+
+func (NopObserver) OnCacheHit(ticketType string) {}
+
+func (NopObserver) OnCacheMiss(ticketType string) {}
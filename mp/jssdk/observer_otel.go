@@ -0,0 +1,93 @@
+package jssdk
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ Observer = (*OTelObserver)(nil)
+
+// OTelObserver 用 OpenTelemetry 把每一次真正向微信服务器发起的 getticket 刷新包装成一个
+// jssdk.updateTicket span, span 上附带 app_id 和 ticket 类型. 是否命中本地缓存不记录在这个
+// span 上, 而是由 OnCacheHit/OnCacheMiss 各自记录成一个独立的 jssdk.cache_lookup span
+// (带 app_id, ticket_type, cache_hit 属性).
+//
+//  NOTE: 同一种 ticketType 可能有多次刷新并发进行(后台定时刷新和某次请求触发的 on-demand
+//  刷新碰巧撞在一起), 所以 span 不能按 ticketType 存在共享的 map 里查找, 而是直接把
+//  trace.Span 本身作为 Observer 接口的 token 在 OnRefreshStart/Success/Error 之间透传.
+type OTelObserver struct {
+	appId  string
+	tracer trace.Tracer
+}
+
+// NewOTelObserver 创建一个新的 OTelObserver.
+//  appId 会作为 span 的属性附加上去, tracerProvider 为 nil 时使用 otel.GetTracerProvider().
+func NewOTelObserver(appId string, tracerProvider trace.TracerProvider) *OTelObserver {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	return &OTelObserver{
+		appId:  appId,
+		tracer: tracerProvider.Tracer("github.com/chanxuehong/wechat/mp/jssdk"),
+	}
+}
+
+func (o *OTelObserver) OnRefreshStart(ticketType string) (token interface{}) {
+	_, span := o.tracer.Start(context.Background(), "jssdk.updateTicket",
+		trace.WithAttributes(
+			attribute.String("app_id", o.appId),
+			attribute.String("ticket_type", ticketType),
+		),
+	)
+	return span
+}
+
+func (o *OTelObserver) OnRefreshSuccess(ticketType, ticket string, expiresIn int64, latency time.Duration, token interface{}) {
+	span, ok := token.(trace.Span)
+	if !ok || span == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.Int64("expires_in", expiresIn),
+		attribute.Int64("latency_ms", latency.Milliseconds()),
+	)
+	span.SetStatus(codes.Ok, "")
+	span.End()
+}
+
+func (o *OTelObserver) OnRefreshError(ticketType string, err error, attempt int, token interface{}) {
+	span, ok := token.(trace.Span)
+	if !ok || span == nil {
+		return
+	}
+	span.SetAttributes(attribute.Int("attempt", attempt))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+func (o *OTelObserver) OnCacheHit(ticketType string) {
+	o.recordCacheLookup(ticketType, true)
+}
+
+func (o *OTelObserver) OnCacheMiss(ticketType string) {
+	o.recordCacheLookup(ticketType, false)
+}
+
+// recordCacheLookup 记录一个独立的短生命周期 span, 不依赖也不修改任何正在进行中的
+// updateTicket span, 避免引入跨 goroutine 共享的可变状态.
+func (o *OTelObserver) recordCacheLookup(ticketType string, hit bool) {
+	_, span := o.tracer.Start(context.Background(), "jssdk.cache_lookup",
+		trace.WithAttributes(
+			attribute.String("app_id", o.appId),
+			attribute.String("ticket_type", ticketType),
+			attribute.Bool("cache_hit", hit),
+		),
+	)
+	span.End()
+}
@@ -0,0 +1,42 @@
+package jssdk
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestOTelObserverConcurrentRefreshesDoNotRace 模拟同一个 ticketType 的两次刷新并发进行
+// (比如后台定时刷新和一次 on-demand 刷新撞在了一起), 每次 OnRefreshStart 返回的 token
+// 必须只对应自己这一次刷新, 不会被另一次覆盖/提前结束.
+func TestOTelObserverConcurrentRefreshesDoNotRace(t *testing.T) {
+	o := NewOTelObserver("wxappid", trace.NewNoopTracerProvider())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token := o.OnRefreshStart(ticketTypeJsapi)
+			time.Sleep(time.Millisecond)
+			if i%2 == 0 {
+				o.OnRefreshSuccess(ticketTypeJsapi, "ticket", 7200, time.Millisecond, token)
+			} else {
+				o.OnRefreshError(ticketTypeJsapi, errors.New("boom"), 0, token)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOTelObserverIgnoresForeignToken(t *testing.T) {
+	o := NewOTelObserver("wxappid", trace.NewNoopTracerProvider())
+
+	// token 类型不对或者为 nil 的时候不应该 panic.
+	o.OnRefreshSuccess(ticketTypeJsapi, "ticket", 7200, time.Millisecond, nil)
+	o.OnRefreshError(ticketTypeJsapi, errors.New("boom"), 0, "not-a-span")
+}
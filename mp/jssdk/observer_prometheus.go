@@ -0,0 +1,91 @@
+package jssdk
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ Observer = (*PrometheusObserver)(nil)
+
+// PrometheusObserver 把 ticket 刷新事件汇报成 Prometheus 指标:
+//  wechat_jsapi_ticket_refresh_total{ticket_type}         刷新成功次数
+//  wechat_jsapi_ticket_refresh_errors_total{ticket_type}  刷新失败次数
+//  wechat_jsapi_ticket_refresh_latency_seconds{ticket_type} 刷新耗时直方图
+//  wechat_jsapi_ticket_expires_in_seconds{ticket_type}    最近一次刷新返回的 expires_in
+//  wechat_jsapi_ticket_cache_hits_total{ticket_type}      本地缓存命中次数
+//  wechat_jsapi_ticket_cache_misses_total{ticket_type}    本地缓存未命中次数
+type PrometheusObserver struct {
+	refreshTotal   *prometheus.CounterVec
+	refreshErrors  *prometheus.CounterVec
+	refreshLatency *prometheus.HistogramVec
+	lastExpiresIn  *prometheus.GaugeVec
+	cacheHitsTotal *prometheus.CounterVec
+	cacheMissTotal *prometheus.CounterVec
+}
+
+// NewPrometheusObserver 创建一个新的 PrometheusObserver, 并把内部的 Collector 注册到 reg.
+// reg 为 nil 时使用 prometheus.DefaultRegisterer.
+func NewPrometheusObserver(reg prometheus.Registerer) *PrometheusObserver {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	o := &PrometheusObserver{
+		refreshTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wechat_jsapi_ticket_refresh_total",
+			Help: "jsapi_ticket/wx_card ticket 刷新成功次数",
+		}, []string{"ticket_type"}),
+		refreshErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wechat_jsapi_ticket_refresh_errors_total",
+			Help: "jsapi_ticket/wx_card ticket 刷新失败次数",
+		}, []string{"ticket_type"}),
+		refreshLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wechat_jsapi_ticket_refresh_latency_seconds",
+			Help:    "jsapi_ticket/wx_card ticket 刷新耗时",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"ticket_type"}),
+		lastExpiresIn: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wechat_jsapi_ticket_expires_in_seconds",
+			Help: "最近一次刷新返回的 expires_in(已经扣除缓冲区)",
+		}, []string{"ticket_type"}),
+		cacheHitsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wechat_jsapi_ticket_cache_hits_total",
+			Help: "本地缓存命中次数",
+		}, []string{"ticket_type"}),
+		cacheMissTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wechat_jsapi_ticket_cache_misses_total",
+			Help: "本地缓存未命中次数",
+		}, []string{"ticket_type"}),
+	}
+
+	reg.MustRegister(
+		o.refreshTotal,
+		o.refreshErrors,
+		o.refreshLatency,
+		o.lastExpiresIn,
+		o.cacheHitsTotal,
+		o.cacheMissTotal,
+	)
+	return o
+}
+
+func (o *PrometheusObserver) OnRefreshStart(ticketType string) (token interface{}) { return nil }
+
+func (o *PrometheusObserver) OnRefreshSuccess(ticketType, ticket string, expiresIn int64, latency time.Duration, token interface{}) {
+	o.refreshTotal.WithLabelValues(ticketType).Inc()
+	o.refreshLatency.WithLabelValues(ticketType).Observe(latency.Seconds())
+	o.lastExpiresIn.WithLabelValues(ticketType).Set(float64(expiresIn))
+}
+
+func (o *PrometheusObserver) OnRefreshError(ticketType string, err error, attempt int, token interface{}) {
+	o.refreshErrors.WithLabelValues(ticketType).Inc()
+}
+
+func (o *PrometheusObserver) OnCacheHit(ticketType string) {
+	o.cacheHitsTotal.WithLabelValues(ticketType).Inc()
+}
+
+func (o *PrometheusObserver) OnCacheMiss(ticketType string) {
+	o.cacheMissTotal.WithLabelValues(ticketType).Inc()
+}
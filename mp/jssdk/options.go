@@ -0,0 +1,75 @@
+package jssdk
+
+import "time"
+
+// RetryPolicy 控制 ticketUpdateDaemon 定时刷新失败之后的重试策略.
+type RetryPolicy struct {
+	MaxRetries int           // 最大重试次数, 0 表示不重试
+	BaseDelay  time.Duration // 第一次重试前的等待时间
+	MaxDelay   time.Duration // 重试等待时间的上限, 每次重试失败后等待时间翻倍, 直到达到这个上限
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  time.Second,
+	MaxDelay:   time.Second * 30,
+}
+
+type defaultTicketServerOptions struct {
+	refreshRatio float64 // 主动刷新的时间点占 expires_in 的比例
+	jitterRatio  float64 // 在 refreshRatio 基础上叠加的随机抖动比例, 避免多实例同时刷新造成惊群
+	retryPolicy  RetryPolicy
+	observer     Observer
+}
+
+func newDefaultTicketServerOptions() defaultTicketServerOptions {
+	return defaultTicketServerOptions{
+		refreshRatio: 0.85,
+		jitterRatio:  0.1,
+		retryPolicy:  defaultRetryPolicy,
+		observer:     NopObserver{},
+	}
+}
+
+// DefaultTicketServerOption 用于定制 NewDefaultTicketServer 的行为.
+type DefaultTicketServerOption func(*defaultTicketServerOptions)
+
+// WithRefreshRatio 设置主动刷新的时间点, ratio 是 expires_in 的比例, 取值范围 (0, 1),
+// 比如 0.85 表示在 ticket 还剩 15% 有效期的时候就主动刷新, 而不是等到快过期才刷新.
+// 默认 0.85, 取值不在 (0, 1) 范围内时忽略.
+func WithRefreshRatio(ratio float64) DefaultTicketServerOption {
+	return func(o *defaultTicketServerOptions) {
+		if ratio > 0 && ratio < 1 {
+			o.refreshRatio = ratio
+		}
+	}
+}
+
+// WithJitter 设置主动刷新时间点的随机抖动比例, 比如 0.1 表示在计算出来的刷新时间点上
+// 再 ±10% 随机浮动, 避免同时部署的多个实例在同一时刻集中刷新. 默认 0.1, 取值不在 [0, 1)
+// 范围内时忽略.
+func WithJitter(ratio float64) DefaultTicketServerOption {
+	return func(o *defaultTicketServerOptions) {
+		if ratio >= 0 && ratio < 1 {
+			o.jitterRatio = ratio
+		}
+	}
+}
+
+// WithRetryPolicy 设置 ticketUpdateDaemon 定时刷新失败之后的重试策略, 默认最多重试 3 次,
+// 初始等待 1s, 之后每次失败等待时间翻倍, 最长等待 30s.
+func WithRetryPolicy(policy RetryPolicy) DefaultTicketServerOption {
+	return func(o *defaultTicketServerOptions) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithObserver 设置 ticket 刷新生命周期的观测回调, 默认是一个什么都不做的 NopObserver.
+// 传入 nil 等价于不设置.
+func WithObserver(observer Observer) DefaultTicketServerOption {
+	return func(o *defaultTicketServerOptions) {
+		if observer != nil {
+			o.observer = observer
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package jssdk
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/chanxuehong/wechat/mp/core"
+)
+
+var _ Cache = (*RedisCache)(nil)
+
+// RedisCache 基于 redis.Pool 实现 Cache 接口.
+type RedisCache struct {
+	pool *redis.Pool
+}
+
+// NewRedisCache 创建一个新的 RedisCache.
+func NewRedisCache(pool *redis.Pool) *RedisCache {
+	if pool == nil {
+		panic("nil redis.Pool")
+	}
+	return &RedisCache{pool: pool}
+}
+
+func (c *RedisCache) Get(key string) (value []byte, exist bool, err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	value, err = redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) (err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	if ttl <= 0 {
+		_, err = conn.Do("SET", key, value)
+	} else {
+		_, err = conn.Do("SET", key, value, "PX", ttl.Nanoseconds()/1e6)
+	}
+	return
+}
+
+func (c *RedisCache) IsExist(key string) (exist bool, err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("EXISTS", key))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c *RedisCache) Delete(key string) (err error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", key)
+	return
+}
+
+var _ Locker = (*RedisLocker)(nil)
+
+// unlockScript 只有 key 对应的 value 仍然等于加锁时写入的 token 才会删除它, 避免释放掉
+// 锁过期之后被其他进程抢到的新锁.
+const unlockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end`
+
+// RedisLocker 基于 redis 的 SET key value NX PX milliseconds 实现分布式锁, value 是
+// 加锁时生成的随机 token, 解锁时通过 Lua 脚本保证 GET+DEL 是原子操作.
+type RedisLocker struct {
+	pool *redis.Pool
+}
+
+// NewRedisLocker 创建一个新的 RedisLocker.
+func NewRedisLocker(pool *redis.Pool) *RedisLocker {
+	if pool == nil {
+		panic("nil redis.Pool")
+	}
+	return &RedisLocker{pool: pool}
+}
+
+func (l *RedisLocker) TryLock(key string, ttl time.Duration) (token string, ok bool, err error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	token = randomNonceStr(32)
+	reply, err := redis.String(conn.Do("SET", key, token, "NX", "PX", ttl.Nanoseconds()/1e6))
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return token, reply == "OK", nil
+}
+
+func (l *RedisLocker) Unlock(key, token string) (err error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("EVAL", unlockScript, 1, key, token)
+	return
+}
+
+// NewRedisTicketServer 创建一个新的基于 Redis 的 DistributedTicketServer, 多个进程
+// 共用同一个 redis.Pool 即可共享同一个 jsapi_ticket.
+func NewRedisTicketServer(clt *core.Client, appId string, pool *redis.Pool) (srv *DistributedTicketServer) {
+	return NewDistributedTicketServer(clt, appId, NewRedisCache(pool), NewRedisLocker(pool))
+}
@@ -0,0 +1,144 @@
+package jssdk
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/chanxuehong/wechat/mp/core"
+)
+
+var _ Cache = (*SQLCache)(nil)
+
+// SQLCache 基于 database/sql 实现 Cache 接口(对 MySQL/SQLite 等支持 REPLACE INTO 的
+// 数据库开箱即用, 其他数据库可以参照实现自己的版本), 使用前需要预先建表:
+//
+//  CREATE TABLE wechat_jsapi_ticket_cache (
+//      cache_key   VARCHAR(191) PRIMARY KEY,
+//      cache_value BLOB         NOT NULL,
+//      expires_at  BIGINT       NOT NULL -- unix 时间戳, 0 表示永不过期
+//  );
+type SQLCache struct {
+	db        *sql.DB
+	tableName string
+}
+
+// NewSQLCache 创建一个新的 SQLCache, tableName 为空时使用默认表名 wechat_jsapi_ticket_cache.
+func NewSQLCache(db *sql.DB, tableName string) *SQLCache {
+	if db == nil {
+		panic("nil sql.DB")
+	}
+	if tableName == "" {
+		tableName = "wechat_jsapi_ticket_cache"
+	}
+	return &SQLCache{db: db, tableName: tableName}
+}
+
+func (c *SQLCache) Get(key string) (value []byte, exist bool, err error) {
+	row := c.db.QueryRow("SELECT cache_value, expires_at FROM "+c.tableName+" WHERE cache_key = ?", key)
+
+	var expiresAt int64
+	if err = row.Scan(&value, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if expiresAt != 0 && expiresAt <= time.Now().Unix() {
+		return nil, false, nil
+	}
+	return value, true, nil
+}
+
+func (c *SQLCache) Set(key string, value []byte, ttl time.Duration) (err error) {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).Unix()
+	}
+	_, err = c.db.Exec(
+		"REPLACE INTO "+c.tableName+" (cache_key, cache_value, expires_at) VALUES (?, ?, ?)",
+		key, value, expiresAt,
+	)
+	return
+}
+
+func (c *SQLCache) IsExist(key string) (exist bool, err error) {
+	_, exist, err = c.Get(key)
+	return
+}
+
+func (c *SQLCache) Delete(key string) (err error) {
+	_, err = c.db.Exec("DELETE FROM "+c.tableName+" WHERE cache_key = ?", key)
+	return
+}
+
+var _ Locker = (*SQLLocker)(nil)
+
+// SQLLocker 基于 database/sql 的主键唯一性实现分布式锁, lock_token 是加锁时生成的随机
+// token, 用来保证只有当前持有者才能释放自己的锁, 使用前需要预先建表:
+//
+//  CREATE TABLE wechat_jsapi_ticket_lock (
+//      lock_key     VARCHAR(191) PRIMARY KEY,
+//      lock_token   VARCHAR(64)  NOT NULL,
+//      locked_until BIGINT       NOT NULL
+//  );
+type SQLLocker struct {
+	db        *sql.DB
+	tableName string
+}
+
+// NewSQLLocker 创建一个新的 SQLLocker, tableName 为空时使用默认表名 wechat_jsapi_ticket_lock.
+func NewSQLLocker(db *sql.DB, tableName string) *SQLLocker {
+	if db == nil {
+		panic("nil sql.DB")
+	}
+	if tableName == "" {
+		tableName = "wechat_jsapi_ticket_lock"
+	}
+	return &SQLLocker{db: db, tableName: tableName}
+}
+
+func (l *SQLLocker) TryLock(key string, ttl time.Duration) (token string, ok bool, err error) {
+	now := time.Now().Unix()
+	lockedUntil := time.Now().Add(ttl).Unix()
+	token = randomNonceStr(32)
+
+	// 锁曾经被持有过且已经过期(或者被正常释放), 直接抢占.
+	res, err := l.db.Exec(
+		"UPDATE "+l.tableName+" SET lock_token = ?, locked_until = ? WHERE lock_key = ? AND locked_until <= ?",
+		token, lockedUntil, key, now,
+	)
+	if err != nil {
+		return "", false, err
+	}
+	if n, err2 := res.RowsAffected(); err2 == nil && n > 0 {
+		return token, true, nil
+	}
+
+	// 锁从来没有被持有过, 尝试插入一行. 这里故意用裸的 INSERT 而不是先 SELECT 再 INSERT:
+	// SELECT ... WHERE NOT EXISTS 判断和插入之间不是原子的, 在没有间隙锁的隔离级别下两个
+	// 实例可能同时看到"不存在"、同时插入, 真正依赖的是 lock_key 主键约束本身的原子性.
+	// 上面的 UPDATE 已经验证过数据库连接/权限等是正常的, 所以这里出错基本上只可能是主键
+	// 冲突(另一个实例抢先插入了), 按锁争用处理, 而不是真正的数据库故障.
+	_, err = l.db.Exec(
+		"INSERT INTO "+l.tableName+" (lock_key, lock_token, locked_until) VALUES (?, ?, ?)",
+		key, token, lockedUntil,
+	)
+	if err != nil {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+func (l *SQLLocker) Unlock(key, token string) (err error) {
+	_, err = l.db.Exec(
+		"UPDATE "+l.tableName+" SET locked_until = 0 WHERE lock_key = ? AND lock_token = ?",
+		key, token,
+	)
+	return
+}
+
+// NewSQLTicketServer 创建一个新的基于 database/sql 的 DistributedTicketServer.
+//  cacheTableName, lockTableName 为空时使用默认表名.
+func NewSQLTicketServer(clt *core.Client, appId string, db *sql.DB, cacheTableName, lockTableName string) (srv *DistributedTicketServer) {
+	return NewDistributedTicketServer(clt, appId, NewSQLCache(db, cacheTableName), NewSQLLocker(db, lockTableName))
+}
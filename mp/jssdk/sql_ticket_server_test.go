@@ -0,0 +1,69 @@
+package jssdk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSQLLockerTryLockContentionVsError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	l := NewSQLLocker(db, "")
+
+	// 锁已经被持有过且尚未过期: UPDATE 不影响任何行, 随后的 INSERT 因为该行已经存在而主键冲突.
+	mock.ExpectExec("UPDATE wechat_jsapi_ticket_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO wechat_jsapi_ticket_lock").WillReturnError(errors.New("UNIQUE constraint failed: lock_key"))
+	token, ok, err := l.TryLock("k", time.Second)
+	if err != nil || ok || token != "" {
+		t.Fatalf("expected contention (ok=false, err=nil), got token=%q ok=%v err=%v", token, ok, err)
+	}
+
+	// 锁从来没被持有过, 两个实例同时抢占同一把锁: UPDATE 不影响任何行(没有旧记录可以
+	// reclaim), 随后裸 INSERT 因为主键冲突而失败 —— 这必须被当成锁争用, 而不是数据库故障.
+	mock.ExpectExec("UPDATE wechat_jsapi_ticket_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO wechat_jsapi_ticket_lock").WillReturnError(errors.New("UNIQUE constraint failed: lock_key"))
+	token, ok, err = l.TryLock("k", time.Second)
+	if err != nil || ok || token != "" {
+		t.Fatalf("expected concurrent first-acquisition to be treated as contention, got token=%q ok=%v err=%v", token, ok, err)
+	}
+
+	// 数据库真正出错(连接断开等)时必须把 err 传递出去, 不能和上面的锁争用混淆. 这个错误
+	// 发生在 UPDATE 阶段, 在插入语句执行之前就能确定不是主键冲突.
+	mock.ExpectExec("UPDATE wechat_jsapi_ticket_lock").WillReturnError(errors.New("connection lost"))
+	token, ok, err = l.TryLock("k", time.Second)
+	if err == nil || ok || token != "" {
+		t.Fatalf("expected genuine error to propagate, got token=%q ok=%v err=%v", token, ok, err)
+	}
+
+	if err2 := mock.ExpectationsWereMet(); err2 != nil {
+		t.Fatalf("unmet expectations: %v", err2)
+	}
+}
+
+func TestSQLLockerUnlockChecksToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer db.Close()
+
+	l := NewSQLLocker(db, "")
+
+	mock.ExpectExec("UPDATE wechat_jsapi_ticket_lock SET locked_until = 0").
+		WithArgs("k", "my-token").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := l.Unlock("k", "my-token"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -7,14 +7,29 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
 	"github.com/chanxuehong/wechat/mp/core"
 )
 
-// jsapi_ticket 中控服务器接口.
+// 微信 getticket 接口支持的 ticket 类型.
+const (
+	ticketTypeJsapi = "jsapi"
+	ticketTypeCard  = "wx_card"
+)
+
+// ticket 中控服务器接口, 同时管理 jsapi_ticket 和 wx_card(卡券) ticket.
 type TicketServer interface {
-	Ticket() (ticket string, err error)                            // 请求中控服务器返回缓存的 jsapi_ticket
-	RefreshTicket(currentTicket string) (ticket string, err error) // 请求中控服务器刷新 jsapi_ticket
-	IIDB04E44A0E1DC11E5ADCEA4DB30FED8E1()                          // 接口标识, 没有实际意义
+	Ticket() (ticket string, err error)                            // 等价于 JsapiTicket, 兼容早期版本
+	RefreshTicket(currentTicket string) (ticket string, err error) // 等价于 RefreshJsapiTicket, 兼容早期版本
+
+	JsapiTicket() (ticket string, err error)                            // 请求中控服务器返回缓存的 jsapi_ticket
+	RefreshJsapiTicket(currentTicket string) (ticket string, err error) // 请求中控服务器刷新 jsapi_ticket
+
+	CardTicket() (ticket string, err error)                            // 请求中控服务器返回缓存的 wx_card ticket
+	RefreshCardTicket(currentTicket string) (ticket string, err error) // 请求中控服务器刷新 wx_card ticket
+
+	IIDB04E44A0E1DC11E5ADCEA4DB30FED8E1() // 接口标识, 没有实际意义
 }
 
 var _ TicketServer = (*DefaultTicketServer)(nil)
@@ -27,105 +42,288 @@ var _ TicketServer = (*DefaultTicketServer)(nil)
 type DefaultTicketServer struct {
 	coreClient *core.Client
 
-	refreshTicketRequestChan  chan string              // chan currentTicket
-	refreshTicketResponseChan chan refreshTicketResult // chan {ticket, err}
+	refreshTicketRequestChan  chan refreshTicketRequest // chan {ticketType, currentTicket}
+	refreshTicketResponseChan chan refreshTicketResult  // chan {ticket, err}
+	tickerRefreshResultChan   chan tickerRefreshResult  // 后台定时刷新 goroutine 的汇报结果
+
+	jsapiTicketCache jsapiTicketCache
+	cardTicketCache  jsapiTicketCache
+
+	options defaultTicketServerOptions
+	sfGroup singleflight.Group // 合并同一时刻对同一个 currentTicket 发起的并发刷新请求
+}
+
+// tickerRefreshResult 是后台定时刷新(带退避重试)完成之后汇报给 ticketUpdateDaemon 的结果.
+type tickerRefreshResult struct {
+	ticketType string
+	ticket     jsapiTicket
+	err        error
+}
 
-	ticketCache jsapiTicketCache
+type refreshTicketRequest struct {
+	ticketType    string
+	currentTicket string
+	attempt       int // 透传给 updateTicket, 只用于上报 Observer, 不影响刷新逻辑本身
 }
 
+// jsapiTicketCache 是进程内的一级缓存, 除了 ticket 本身还记录了一个绝对过期时间, 过期之后
+// getTicket 返回零值, 逼迫调用方重新查一次上一级缓存(共享 Cache 或者 ticketUpdateDaemon).
+//  NOTE: DefaultTicketServer 自己的 ticketUpdateDaemon 会在过期之前提前刷新, expiresAt 在
+//  这种场景下基本不会触发; 但 DistributedTicketServer 没有后台 daemon, 完全依赖这个过期
+//  检查才能避免把同一个 ticket 永久缓存下去.
 type jsapiTicketCache struct {
 	sync.RWMutex
-	ticket jsapiTicket
+	ticket    jsapiTicket
+	expiresAt int64 // unix 时间戳, 0 表示当前没有缓存
 }
 
 func (cache *jsapiTicketCache) getTicket() (ticket jsapiTicket) {
 	cache.RLock()
-	ticket = cache.ticket
-	cache.RUnlock()
-	return
+	defer cache.RUnlock()
+	if cache.expiresAt != 0 && cache.expiresAt <= time.Now().Unix() {
+		return jsapiTicket{}
+	}
+	return cache.ticket
 }
 
+// putTicket 缓存一个刚刚获取到的 ticket, ticket.ExpiresIn 是从现在开始算起的剩余有效期.
 func (cache *jsapiTicketCache) putTicket(ticket jsapiTicket) {
+	var expiresAt int64
+	if ticket.Ticket != "" {
+		expiresAt = time.Now().Unix() + ticket.ExpiresIn
+	}
+	cache.putTicketUntil(ticket, expiresAt)
+}
+
+// putTicketUntil 缓存一个 ticket 并显式指定它的绝对过期时间, 用于从别处(比如共享 Cache
+// 里的 distributedTicketEntry)复制过来的 ticket, 这种情况下 ticket.ExpiresIn 是最初写入
+// 共享 Cache 时的原始有效期, 不能再当作"从现在起"的剩余时间使用.
+func (cache *jsapiTicketCache) putTicketUntil(ticket jsapiTicket, expiresAt int64) {
 	cache.Lock()
 	cache.ticket = ticket
+	cache.expiresAt = expiresAt
 	cache.Unlock()
 }
 
 // NewDefaultTicketServer 创建一个新的 DefaultTicketServer.
-func NewDefaultTicketServer(clt *core.Client) (srv *DefaultTicketServer) {
+//  options 用于定制主动刷新的时间点(WithRefreshRatio), 抖动(WithJitter) 以及刷新失败
+//  之后的重试策略(WithRetryPolicy), 不传则使用默认值, 不影响现有调用方.
+func NewDefaultTicketServer(clt *core.Client, options ...DefaultTicketServerOption) (srv *DefaultTicketServer) {
 	if clt == nil {
 		panic("nil core.Client")
 	}
+	opts := newDefaultTicketServerOptions()
+	for _, option := range options {
+		option(&opts)
+	}
+
 	srv = &DefaultTicketServer{
 		coreClient:                clt,
-		refreshTicketRequestChan:  make(chan string),
+		refreshTicketRequestChan:  make(chan refreshTicketRequest),
 		refreshTicketResponseChan: make(chan refreshTicketResult),
+		tickerRefreshResultChan:   make(chan tickerRefreshResult),
+		options:                   opts,
 	}
 
-	go srv.ticketUpdateDaemon(time.Hour * 24 * time.Duration(100+rand.Int63n(200)))
+	randomInitTickDuration := func() time.Duration {
+		return time.Hour * 24 * time.Duration(100+rand.Int63n(200))
+	}
+	go srv.ticketUpdateDaemon(randomInitTickDuration(), randomInitTickDuration())
 	return
 }
 
 func (srv *DefaultTicketServer) IIDB04E44A0E1DC11E5ADCEA4DB30FED8E1() {}
 
 func (srv *DefaultTicketServer) Ticket() (ticket string, err error) {
-	if ticket = srv.ticketCache.getTicket().Ticket; ticket != "" {
+	return srv.JsapiTicket()
+}
+
+func (srv *DefaultTicketServer) RefreshTicket(currentTicket string) (ticket string, err error) {
+	return srv.RefreshJsapiTicket(currentTicket)
+}
+
+func (srv *DefaultTicketServer) JsapiTicket() (ticket string, err error) {
+	if ticket = srv.jsapiTicketCache.getTicket().Ticket; ticket != "" {
+		srv.options.observer.OnCacheHit(ticketTypeJsapi)
+		return
+	}
+	srv.options.observer.OnCacheMiss(ticketTypeJsapi)
+	return srv.RefreshJsapiTicket("")
+}
+
+func (srv *DefaultTicketServer) RefreshJsapiTicket(currentTicket string) (ticket string, err error) {
+	return srv.refreshTicket(ticketTypeJsapi, currentTicket)
+}
+
+func (srv *DefaultTicketServer) CardTicket() (ticket string, err error) {
+	if ticket = srv.cardTicketCache.getTicket().Ticket; ticket != "" {
+		srv.options.observer.OnCacheHit(ticketTypeCard)
 		return
 	}
-	return srv.RefreshTicket("")
+	srv.options.observer.OnCacheMiss(ticketTypeCard)
+	return srv.RefreshCardTicket("")
+}
+
+func (srv *DefaultTicketServer) RefreshCardTicket(currentTicket string) (ticket string, err error) {
+	return srv.refreshTicket(ticketTypeCard, currentTicket)
 }
 
 type refreshTicketResult struct {
-	ticket string
+	ticket jsapiTicket
 	err    error
 }
 
-func (srv *DefaultTicketServer) RefreshTicket(currentTicket string) (ticket string, err error) {
-	srv.refreshTicketRequestChan <- currentTicket
-	rslt := <-srv.refreshTicketResponseChan
-	return rslt.ticket, rslt.err
+// refreshTicket 是 JsapiTicket/CardTicket 等 on-demand 路径的入口, attempt 固定为 0.
+func (srv *DefaultTicketServer) refreshTicket(ticketType, currentTicket string) (ticket string, err error) {
+	rslt, err := srv.refreshTicketAttempt(ticketType, currentTicket, 0)
+	if err != nil {
+		return
+	}
+	return rslt.Ticket, nil
+}
+
+// refreshTicketAttempt 把 ticketType/currentTicket 相同的并发刷新请求用 singleflight 合并成
+// 一次对 ticketUpdateDaemon 的请求. ticketUpdateDaemon 的后台定时刷新(backgroundRefresh/
+// refreshTicketWithRetry)也要走这同一个入口, 而不是绕过 sfGroup 直接调用 updateTicket,
+// 否则一次 currentTicket 为空的 on-demand 刷新和一次碰巧同时发生的后台重试会各自触发一次
+// getticket 调用. currentTicket 不同的请求(比如 RefreshTicket(非空)和后台重试)不会被合并.
+// attempt 透传给 updateTicket, 只用于上报 Observer.
+func (srv *DefaultTicketServer) refreshTicketAttempt(ticketType, currentTicket string, attempt int) (ticket jsapiTicket, err error) {
+	key := ticketType + "|" + currentTicket
+	v, err, _ := srv.sfGroup.Do(key, func() (interface{}, error) {
+		srv.refreshTicketRequestChan <- refreshTicketRequest{ticketType: ticketType, currentTicket: currentTicket, attempt: attempt}
+		rslt := <-srv.refreshTicketResponseChan
+		if rslt.err != nil {
+			return jsapiTicket{}, rslt.err
+		}
+		return rslt.ticket, nil
+	})
+	if err != nil {
+		// updateTicket 内部已经上报过这次刷新失败(携带了正确的 token), 这里不用重复上报.
+		return jsapiTicket{}, err
+	}
+	return v.(jsapiTicket), nil
 }
 
-func (srv *DefaultTicketServer) ticketUpdateDaemon(initTickDuration time.Duration) {
-	tickDuration := initTickDuration
+// cacheForTicketType 返回指定 ticket 类型对应的本地缓存.
+func (srv *DefaultTicketServer) cacheForTicketType(ticketType string) *jsapiTicketCache {
+	if ticketType == ticketTypeCard {
+		return &srv.cardTicketCache
+	}
+	return &srv.jsapiTicketCache
+}
+
+// ticketUpdateDaemon 是整个 DefaultTicketServer 唯一的协调者, 所有对 jsapi_ticket/wx_card
+// ticket 的读写都要经过它串行化. jsapiTicker, cardTicker 各自独立地触发两种 ticket 的主动
+// 提前刷新.
+//
+//  NOTE: 定时刷新的重试退避(refreshTicketWithRetry 里的 time.Sleep)绝不能放在这个 for-select
+//  循环里同步执行, 否则退避期间 refreshTicketRequestChan 上的所有请求(JsapiTicket/CardTicket/
+//  RefreshTicket 等)都会被阻塞住. 所以 ticker 触发时只是 go 一个后台 goroutine 去做重试,
+//  daemon 自己继续处理其他请求, 重试结束后通过 tickerRefreshResultChan 把结果带回来.
+func (srv *DefaultTicketServer) ticketUpdateDaemon(initJsapiTickDuration, initCardTickDuration time.Duration) {
+	jsapiTicker := time.NewTicker(initJsapiTickDuration)
+	cardTicker := time.NewTicker(initCardTickDuration)
+	defer jsapiTicker.Stop()
+	defer cardTicker.Stop()
+
+	// 避免上一次的后台刷新还没返回结果, 同一种 ticket 类型的 ticker 又触发了一次重复刷新.
+	var jsapiRefreshing, cardRefreshing bool
 
-NEW_TICK_DURATION:
-	ticker := time.NewTicker(tickDuration)
 	for {
 		select {
-		case currentTicket := <-srv.refreshTicketRequestChan:
-			jsapiTicket, cached, err := srv.updateTicket(currentTicket)
+		case req := <-srv.refreshTicketRequestChan:
+			ticket, cached, err := srv.updateTicket(req.ticketType, req.currentTicket, req.attempt)
 			if err != nil {
 				srv.refreshTicketResponseChan <- refreshTicketResult{err: err}
-				break
+				continue
 			}
-			srv.refreshTicketResponseChan <- refreshTicketResult{ticket: jsapiTicket.Ticket}
+			srv.refreshTicketResponseChan <- refreshTicketResult{ticket: ticket}
 			if !cached {
-				tickDuration = time.Duration(jsapiTicket.ExpiresIn) * time.Second
-				ticker.Stop()
-				goto NEW_TICK_DURATION
+				srv.resetTicker(req.ticketType, jsapiTicker, cardTicker, ticket.ExpiresIn)
 			}
 
-		case <-ticker.C:
-			jsapiTicket, _, err := srv.updateTicket("")
-			if err != nil {
-				break
+		case <-jsapiTicker.C:
+			if jsapiRefreshing {
+				continue
+			}
+			jsapiRefreshing = true
+			go srv.backgroundRefresh(ticketTypeJsapi)
+
+		case <-cardTicker.C:
+			if cardRefreshing {
+				continue
+			}
+			cardRefreshing = true
+			go srv.backgroundRefresh(ticketTypeCard)
+
+		case rslt := <-srv.tickerRefreshResultChan:
+			if rslt.ticketType == ticketTypeCard {
+				cardRefreshing = false
+			} else {
+				jsapiRefreshing = false
 			}
-			newTickDuration := time.Duration(jsapiTicket.ExpiresIn) * time.Second
-			if abs(tickDuration-newTickDuration) > time.Second*2 {
-				tickDuration = newTickDuration
-				ticker.Stop()
-				goto NEW_TICK_DURATION
+			if rslt.err != nil {
+				continue
 			}
+			srv.resetTicker(rslt.ticketType, jsapiTicker, cardTicker, rslt.ticket.ExpiresIn)
 		}
 	}
 }
 
-func abs(x time.Duration) time.Duration {
-	if x >= 0 {
-		return x
+// backgroundRefresh 在独立的 goroutine 里完成一次(带退避重试的)主动刷新, 结果通过
+// tickerRefreshResultChan 汇报给 ticketUpdateDaemon, 不占用 daemon 自己的 goroutine.
+func (srv *DefaultTicketServer) backgroundRefresh(ticketType string) {
+	ticket, err := srv.refreshTicketWithRetry(ticketType)
+	srv.tickerRefreshResultChan <- tickerRefreshResult{ticketType: ticketType, ticket: ticket, err: err}
+}
+
+// resetTicker 根据最新的 expiresIn 重新安排指定 ticket 类型下一次主动刷新的时间.
+func (srv *DefaultTicketServer) resetTicker(ticketType string, jsapiTicker, cardTicker *time.Ticker, expiresIn int64) {
+	d := srv.nextRefreshDuration(expiresIn)
+	if ticketType == ticketTypeCard {
+		cardTicker.Reset(d)
+	} else {
+		jsapiTicker.Reset(d)
+	}
+}
+
+// nextRefreshDuration 按照 options.refreshRatio 和 options.jitterRatio 计算下一次主动
+// 刷新的时间间隔, 而不是等到 expiresIn 整个过期才刷新, 避免大量实例在同一时刻集中刷新.
+func (srv *DefaultTicketServer) nextRefreshDuration(expiresIn int64) time.Duration {
+	base := float64(expiresIn) * srv.options.refreshRatio
+	jitter := base * srv.options.jitterRatio * (rand.Float64()*2 - 1) // ±jitterRatio
+	d := base + jitter
+	if d < 1 {
+		d = 1
+	}
+	return time.Duration(d * float64(time.Second))
+}
+
+// refreshTicketWithRetry 按照 options.retryPolicy 对定时刷新做指数退避重试, 避免偶发的
+// 网络错误导致 ticket 一直刷新不出来.
+//
+//  NOTE: 每次尝试都通过 refreshTicketAttempt 走 sfGroup, 而不是直接调用 updateTicket,
+//  这样即使这次重试和某个 goroutine 碰巧同时发起的 currentTicket 为空的 on-demand 刷新
+//  (比如 JsapiTicket/CardTicket 没有命中本地缓存)撞在一起, 也只会真正发起一次 getticket 请求.
+func (srv *DefaultTicketServer) refreshTicketWithRetry(ticketType string) (ticket jsapiTicket, err error) {
+	policy := srv.options.retryPolicy
+	delay := policy.BaseDelay
+
+	for attempt := 0; ; attempt++ {
+		ticket, err = srv.refreshTicketAttempt(ticketType, "", attempt)
+		if err == nil {
+			return
+		}
+		if attempt >= policy.MaxRetries {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
 	}
-	return -x
 }
 
 var zeroJsapiTicket jsapiTicket
@@ -135,49 +333,64 @@ type jsapiTicket struct {
 	ExpiresIn int64  `json:"expires_in"`
 }
 
-// updateTicket 从微信服务器获取新的 jsapi_ticket 并存入缓存, 同时返回该 jsapi_ticket.
-func (srv *DefaultTicketServer) updateTicket(currentTicket string) (ticket jsapiTicket, cached bool, err error) {
-	if ticket = srv.ticketCache.getTicket(); currentTicket != "" && ticket.Ticket != "" && currentTicket != ticket.Ticket {
+// updateTicket 从微信服务器获取指定类型的新 ticket 并存入对应的缓存, 同时返回该 ticket.
+// attempt 是 refreshTicketWithRetry 传下来的重试次数(从 0 开始), 只用于上报给 Observer.
+func (srv *DefaultTicketServer) updateTicket(ticketType, currentTicket string, attempt int) (ticket jsapiTicket, cached bool, err error) {
+	cache := srv.cacheForTicketType(ticketType)
+
+	if ticket = cache.getTicket(); currentTicket != "" && ticket.Ticket != "" && currentTicket != ticket.Ticket {
 		cached = true
 		return
 	}
 
-	var incompleteURL = "https://api.weixin.qq.com/cgi-bin/ticket/getticket?type=jsapi&access_token="
+	token := srv.options.observer.OnRefreshStart(ticketType)
+	start := time.Now()
+
+	var incompleteURL = "https://api.weixin.qq.com/cgi-bin/ticket/getticket?type=" + ticketType + "&access_token="
 	var result struct {
 		core.Error
 		jsapiTicket
 	}
 	if err = srv.coreClient.GetJSON(incompleteURL, &result); err != nil {
-		srv.ticketCache.putTicket(zeroJsapiTicket)
+		cache.putTicket(zeroJsapiTicket)
+		srv.options.observer.OnRefreshError(ticketType, err, attempt, token)
 		return
 	}
 	if result.ErrCode != core.ErrCodeOK {
-		srv.ticketCache.putTicket(zeroJsapiTicket)
+		cache.putTicket(zeroJsapiTicket)
 		err = &result.Error
+		srv.options.observer.OnRefreshError(ticketType, err, attempt, token)
 		return
 	}
 
-	// 由于网络的延时, jsapi_ticket 过期时间留有一个缓冲区
-	switch {
-	case result.ExpiresIn > 31556952: // 60*60*24*365.2425
-		srv.ticketCache.putTicket(zeroJsapiTicket)
-		err = errors.New("expires_in too large: " + strconv.FormatInt(result.ExpiresIn, 10))
-		return
-	case result.ExpiresIn > 60*60:
-		result.ExpiresIn -= 60 * 10
-	case result.ExpiresIn > 60*30:
-		result.ExpiresIn -= 60 * 5
-	case result.ExpiresIn > 60*5:
-		result.ExpiresIn -= 60
-	case result.ExpiresIn > 60:
-		result.ExpiresIn -= 10
-	default:
-		srv.ticketCache.putTicket(zeroJsapiTicket)
-		err = errors.New("expires_in too small: " + strconv.FormatInt(result.ExpiresIn, 10))
+	// 由于网络的延时, ticket 过期时间留有一个缓冲区
+	if result.ExpiresIn, err = trimTicketExpiresIn(result.ExpiresIn); err != nil {
+		cache.putTicket(zeroJsapiTicket)
+		srv.options.observer.OnRefreshError(ticketType, err, attempt, token)
 		return
 	}
 
-	srv.ticketCache.putTicket(result.jsapiTicket)
+	cache.putTicket(result.jsapiTicket)
 	ticket = result.jsapiTicket
+	srv.options.observer.OnRefreshSuccess(ticketType, ticket.Ticket, ticket.ExpiresIn, time.Since(start), token)
 	return
 }
+
+// trimTicketExpiresIn 在 expires_in 的基础上留出一个缓冲区, 避免因为网络延时导致缓存的
+// ticket 在外部世界已经过期了还在使用.
+func trimTicketExpiresIn(expiresIn int64) (trimmed int64, err error) {
+	switch {
+	case expiresIn > 31556952: // 60*60*24*365.2425
+		return 0, errors.New("expires_in too large: " + strconv.FormatInt(expiresIn, 10))
+	case expiresIn > 60*60:
+		return expiresIn - 60*10, nil
+	case expiresIn > 60*30:
+		return expiresIn - 60*5, nil
+	case expiresIn > 60*5:
+		return expiresIn - 60, nil
+	case expiresIn > 60:
+		return expiresIn - 10, nil
+	default:
+		return 0, errors.New("expires_in too small: " + strconv.FormatInt(expiresIn, 10))
+	}
+}
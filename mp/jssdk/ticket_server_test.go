@@ -0,0 +1,46 @@
+package jssdk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRefreshDurationRespectsRefreshRatio(t *testing.T) {
+	srv := &DefaultTicketServer{options: newDefaultTicketServerOptions()}
+	srv.options.refreshRatio = 0.5
+	srv.options.jitterRatio = 0 // 关掉抖动, 结果应该是确定的
+
+	got := srv.nextRefreshDuration(1000)
+	want := 500 * time.Second
+	if got != want {
+		t.Fatalf("nextRefreshDuration(1000) = %v, want %v", got, want)
+	}
+}
+
+func TestNextRefreshDurationNeverNonPositive(t *testing.T) {
+	srv := &DefaultTicketServer{options: newDefaultTicketServerOptions()}
+	srv.options.refreshRatio = 0.5
+	srv.options.jitterRatio = 0.9 // 很大的抖动也不应该把结果抖成 <= 0
+
+	for expiresIn := int64(1); expiresIn <= 5; expiresIn++ {
+		if d := srv.nextRefreshDuration(expiresIn); d <= 0 {
+			t.Fatalf("nextRefreshDuration(%d) = %v, want > 0", expiresIn, d)
+		}
+	}
+}
+
+func TestNextRefreshDurationJitterBounded(t *testing.T) {
+	srv := &DefaultTicketServer{options: newDefaultTicketServerOptions()}
+	srv.options.refreshRatio = 1
+	srv.options.jitterRatio = 0.1
+
+	base := 1000 * time.Second
+	lo := time.Duration(float64(base) * 0.9)
+	hi := time.Duration(float64(base) * 1.1)
+	for i := 0; i < 50; i++ {
+		d := srv.nextRefreshDuration(1000)
+		if d < lo || d > hi {
+			t.Fatalf("nextRefreshDuration jitter out of bounds: %v not in [%v, %v]", d, lo, hi)
+		}
+	}
+}